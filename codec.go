@@ -0,0 +1,281 @@
+package openapimux
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec decodes request payloads and encodes response payloads for a set of
+// content types.
+type Codec interface {
+	ContentTypes() []string
+	Decode(r *http.Request, v any) error
+	Encode(w http.ResponseWriter, status int, v any) error
+}
+
+// RegisterCodec registers codec for each of the content types it declares,
+// overriding any codec previously registered for those content types. JSON,
+// XML, form and msgpack codecs are registered by default.
+func (sr *OpenAPIMux) RegisterCodec(codec Codec) {
+	if sr.codecs == nil {
+		sr.codecs = make(map[string]Codec)
+	}
+
+	for _, ct := range codec.ContentTypes() {
+		sr.codecs[ct] = codec
+	}
+}
+
+func (sr *OpenAPIMux) registerDefaultCodecs() {
+	sr.RegisterCodec(jsonCodec{})
+	sr.RegisterCodec(xmlCodec{})
+	sr.RegisterCodec(formCodec{})
+	sr.RegisterCodec(msgpackCodec{})
+}
+
+// negotiateRequestCodec picks the codec a handler should use to decode the
+// request body, from the request's Content-Type matched against the route's
+// declared requestBody.content, falling back to JSON. It never consults the
+// Accept header or the declared response content types - those only govern
+// the response codec.
+func (sr *OpenAPIMux) negotiateRequestCodec(r *http.Request, route *openapi3filter.Route) Codec {
+	declared := requestContentTypes(route)
+
+	if codec := sr.matchCodec(mediaType(r.Header.Get("Content-Type")), declared); codec != nil {
+		return codec
+	}
+
+	return sr.codecs["application/json"]
+}
+
+// negotiateResponseCodec picks the codec a handler should use to encode the
+// response, preferring the client's Accept header matched against the
+// route's declared responses[*].content, falling back to JSON. It never
+// consults the Content-Type header or the declared request content types -
+// those only govern the request codec.
+func (sr *OpenAPIMux) negotiateResponseCodec(r *http.Request, route *openapi3filter.Route) Codec {
+	declared := responseContentTypes(route)
+
+	for _, ct := range parseAccept(r.Header.Get("Accept")) {
+		if codec := sr.matchCodec(ct, declared); codec != nil {
+			return codec
+		}
+	}
+
+	return sr.codecs["application/json"]
+}
+
+func (sr *OpenAPIMux) matchCodec(ct string, declared map[string]bool) Codec {
+	if ct == "" {
+		return nil
+	}
+
+	if len(declared) > 0 && !declared[ct] {
+		return nil
+	}
+
+	return sr.codecs[ct]
+}
+
+// requestContentTypes returns the content types declared by route's
+// requestBody. An empty result means the schema didn't declare any (or route
+// is nil), in which case any registered codec is acceptable.
+func requestContentTypes(route *openapi3filter.Route) map[string]bool {
+	types := map[string]bool{}
+
+	if route == nil || route.Operation == nil {
+		return types
+	}
+
+	if body := route.Operation.RequestBody; body != nil && body.Value != nil {
+		for ct := range body.Value.Content {
+			types[ct] = true
+		}
+	}
+
+	return types
+}
+
+// responseContentTypes returns the union of content types declared across
+// route's responses. An empty result means the schema didn't declare any (or
+// route is nil), in which case any registered codec is acceptable.
+func responseContentTypes(route *openapi3filter.Route) map[string]bool {
+	types := map[string]bool{}
+
+	if route == nil || route.Operation == nil {
+		return types
+	}
+
+	for _, response := range route.Operation.Responses {
+		if response == nil || response.Value == nil {
+			continue
+		}
+
+		for ct := range response.Value.Content {
+			types[ct] = true
+		}
+	}
+
+	return types
+}
+
+// mediaType strips parameters (e.g. "; charset=utf-8") from a Content-Type header.
+func mediaType(header string) string {
+	ct, _, e := mime.ParseMediaType(header)
+	if e != nil {
+		return ""
+	}
+
+	return ct
+}
+
+// parseAccept splits an Accept header into media types in preference order,
+// ignoring quality values and wildcards.
+func parseAccept(header string) []string {
+	var types []string
+
+	for _, part := range strings.Split(header, ",") {
+		ct := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if ct == "" || ct == "*/*" {
+			continue
+		}
+
+		types = append(types, ct)
+	}
+
+	return types
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentTypes() []string { return []string{"application/json"} }
+
+func (jsonCodec) Decode(r *http.Request, v any) error {
+	if r.Body == nil {
+		return nil
+	}
+
+	switch e := json.NewDecoder(r.Body).Decode(v); e {
+	case nil, io.EOF:
+		return nil
+	default:
+		return e
+	}
+}
+
+func (jsonCodec) Encode(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(v)
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentTypes() []string { return []string{"application/xml", "text/xml"} }
+
+func (xmlCodec) Decode(r *http.Request, v any) error {
+	if r.Body == nil {
+		return nil
+	}
+
+	switch e := xml.NewDecoder(r.Body).Decode(v); e {
+	case nil, io.EOF:
+		return nil
+	default:
+		return e
+	}
+}
+
+func (xmlCodec) Encode(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// formCodec round-trips application/x-www-form-urlencoded through JSON so it
+// can decode/encode into the same Req/Resp types the other codecs use.
+type formCodec struct{}
+
+func (formCodec) ContentTypes() []string { return []string{"application/x-www-form-urlencoded"} }
+
+func (formCodec) Decode(r *http.Request, v any) error {
+	if e := r.ParseForm(); e != nil {
+		return e
+	}
+
+	data := make(map[string]any, len(r.PostForm))
+	for key, values := range r.PostForm {
+		if len(values) == 1 {
+			data[key] = values[0]
+		} else {
+			data[key] = values
+		}
+	}
+
+	encoded, e := json.Marshal(data)
+	if e != nil {
+		return e
+	}
+
+	return json.Unmarshal(encoded, v)
+}
+
+func (formCodec) Encode(w http.ResponseWriter, status int, v any) error {
+	encoded, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+
+	var data map[string]any
+	if e := json.Unmarshal(encoded, &data); e != nil {
+		return e
+	}
+
+	values := make(url.Values, len(data))
+	for key, value := range data {
+		values.Set(key, fmt.Sprintf("%v", value))
+	}
+
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	w.WriteHeader(status)
+	_, e = w.Write([]byte(values.Encode()))
+
+	return e
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack"}
+}
+
+func (msgpackCodec) Decode(r *http.Request, v any) error {
+	if r.Body == nil {
+		return nil
+	}
+
+	switch e := msgpack.NewDecoder(r.Body).Decode(v); e {
+	case nil, io.EOF:
+		return nil
+	default:
+		return e
+	}
+}
+
+func (msgpackCodec) Encode(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/msgpack")
+	w.WriteHeader(status)
+
+	return msgpack.NewEncoder(w).Encode(v)
+}
@@ -2,7 +2,11 @@ package openapimux
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"sync"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/openapi3filter"
@@ -23,12 +27,54 @@ import (
 //  - url: "/v1.2"
 //* When finding a matching route, routers with "servers" attribute set take priority
 type OpenAPIMux struct {
-	handler       http.Handler
-	handlers      map[string]http.Handler
-	middlewares   []func(http.Handler) http.Handler
-	Routers       *openapi3filter.Routers
-	ErrorHandler  func(http.ResponseWriter, *http.Request, string, int)
-	DetailedError bool
+	handler              http.Handler
+	handlers             map[string]http.Handler
+	middlewares          []func(http.Handler) http.Handler
+	operationMiddleware  map[string][]func(http.Handler) http.Handler
+	tagMiddleware        map[string][]func(http.Handler) http.Handler
+	handlerCache         map[string]http.Handler
+	handlerCacheMu       sync.Mutex
+	swaggers             []*openapi3.Swagger
+	codecs               map[string]Codec
+	fallback             http.Handler
+	Routers              *openapi3filter.Routers
+	ErrorHandler         func(http.ResponseWriter, *http.Request, string, int)
+	DetailedError        bool
+	ValidateResponses    bool
+	ResponseErrorHandler ResponseErrorHandler
+	ErrorMapper          ErrorMapper
+}
+
+// ResponseErrorHandler is invoked when a handled response fails validation
+// against the OpenAPI schema. resp carries the status, headers and body the
+// handler produced, already buffered and safe to read or discard. Implementations
+// decide what reaches the client: call resp.WriteTo(w) to pass it through unchanged,
+// write a different response to w, or just log and rely on DefaultResponseErrorHandler
+// semantics by also calling it.
+type ResponseErrorHandler func(w http.ResponseWriter, r *http.Request, resp *BufferedResponse, err error)
+
+// BufferedResponse is the recorded output of a handler, captured so it can be
+// validated before anything reaches the real http.ResponseWriter.
+type BufferedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// WriteTo writes the buffered response to w unchanged.
+func (b *BufferedResponse) WriteTo(w http.ResponseWriter) {
+	for key, values := range b.Header {
+		w.Header()[key] = values
+	}
+
+	w.WriteHeader(b.Status)
+	w.Write(b.Body)
+}
+
+// DefaultResponseErrorHandler discards the buffered response and replaces it with
+// a 500, using the same Respond helper ErrorHandler defaults to.
+func DefaultResponseErrorHandler(w http.ResponseWriter, r *http.Request, resp *BufferedResponse, err error) {
+	Respond(w, r, err.Error(), http.StatusInternalServerError)
 }
 
 // contextKey is a value for use with context.WithValue. It's used as
@@ -38,25 +84,49 @@ type contextKey struct {
 }
 
 var pathParamsKey = &contextKey{"pathParams"}
+var routeKey = &contextKey{"route"}
+var requestCodecKey = &contextKey{"requestCodec"}
+var responseCodecKey = &contextKey{"responseCodec"}
 
-//NewRouter creates a OpenAPIMux from API definitions
-func NewRouter(apis ...string) (*OpenAPIMux, error) {
-	routers := make(openapi3filter.Routers, len(apis))
+//NewRouter creates a OpenAPIMux from API definitions loaded via the given
+//RouterOptions, e.g. NewRouter(FromFile("./v1.yaml")) or
+//NewRouter(FromSwagger(sw)). Plain strings are accepted too, dispatched to
+//FromFile, for back-compat with the original NewRouter(apis ...string).
+func NewRouter(options ...interface{}) (*OpenAPIMux, error) {
+	cfg := &routerConfig{}
 
-	for i, api := range apis {
-		swagger, e := openapi3.NewSwaggerLoader().LoadSwaggerFromFile(api)
-		if e != nil {
+	for _, option := range options {
+		var opt RouterOption
+
+		switch o := option.(type) {
+		case string:
+			opt = FromFile(o)
+		case RouterOption:
+			opt = o
+		default:
+			return nil, fmt.Errorf("openapimux: NewRouter: unsupported option type %T", option)
+		}
+
+		if e := opt(cfg); e != nil {
 			return nil, e
 		}
+	}
 
+	routers := make(openapi3filter.Routers, len(cfg.swaggers))
+
+	for i, swagger := range cfg.swaggers {
 		routers[i] = openapi3filter.NewRouter().WithSwagger(swagger)
 	}
 
-	return &OpenAPIMux{
+	mux := &OpenAPIMux{
 		Routers:       &routers,
+		swaggers:      cfg.swaggers,
 		ErrorHandler:  Respond,
 		DetailedError: true,
-	}, nil
+	}
+	mux.registerDefaultCodecs()
+
+	return mux, nil
 }
 
 // ServeHTTP is the single method of the http.Handler interface that makes
@@ -87,6 +157,63 @@ func (sr *OpenAPIMux) UseMiddleware(middlewares ...func(http.Handler) http.Handl
 	sr.middlewares = append(sr.middlewares, middlewares...)
 }
 
+// Fallback sets the handler a request is delegated to when it doesn't match
+// any operation in the loaded schemas, instead of responding 404. That covers
+// an unknown path, a known path with no operation for the request's method,
+// and an operation without an operationId. This allows
+// incremental adoption: new routes go through the OpenAPI-validated mux,
+// legacy ones fall through to h.
+func (sr *OpenAPIMux) Fallback(h http.Handler) {
+	sr.fallback = h
+}
+
+// UseMiddlewareFor attaches middleware that only runs for the operation identified
+// by operationID, between the tag-scoped and global middleware stacks and the
+// operation's handler.
+func (sr *OpenAPIMux) UseMiddlewareFor(operationID string, middlewares ...func(http.Handler) http.Handler) {
+	if sr.operationMiddleware == nil {
+		sr.operationMiddleware = make(map[string][]func(http.Handler) http.Handler)
+	}
+
+	sr.operationMiddleware[operationID] = append(sr.operationMiddleware[operationID], middlewares...)
+}
+
+// UseMiddlewareForTag attaches middleware that runs for every operation sharing the
+// given OpenAPI tag, between the global middleware stack and any operation-scoped
+// or tag-scoped middleware.
+func (sr *OpenAPIMux) UseMiddlewareForTag(tag string, middlewares ...func(http.Handler) http.Handler) {
+	if sr.tagMiddleware == nil {
+		sr.tagMiddleware = make(map[string][]func(http.Handler) http.Handler)
+	}
+
+	sr.tagMiddleware[tag] = append(sr.tagMiddleware[tag], middlewares...)
+}
+
+// resolveHandler builds and caches the handler chain for an operation as
+// global -> tag -> operation -> handler. It's resolved once per operationID,
+// the first time that operation is requested.
+func (sr *OpenAPIMux) resolveHandler(operationID string, tags []string, handler http.Handler) http.Handler {
+	sr.handlerCacheMu.Lock()
+	defer sr.handlerCacheMu.Unlock()
+
+	if h, ok := sr.handlerCache[operationID]; ok {
+		return h
+	}
+
+	h := chain(sr.operationMiddleware[operationID], handler)
+	for _, tag := range tags {
+		h = chain(sr.tagMiddleware[tag], h)
+	}
+
+	if sr.handlerCache == nil {
+		sr.handlerCache = make(map[string]http.Handler)
+	}
+
+	sr.handlerCache[operationID] = h
+
+	return h
+}
+
 //PathParam returns the in-context path params for a request by name.
 func PathParam(r *http.Request, key string) string {
 	pathParams, ok := r.Context().Value(pathParamsKey).(map[string]string)
@@ -105,6 +232,11 @@ func PathParam(r *http.Request, key string) string {
 func (sr *OpenAPIMux) handleRequest(w http.ResponseWriter, r *http.Request) {
 	_, route, pathParams, e := sr.Routers.FindRoute(r.Method, r.URL)
 	if route == nil || route.Operation == nil || route.Operation.OperationID == "" || e != nil {
+		if sr.fallback != nil {
+			sr.fallback.ServeHTTP(w, r)
+			return
+		}
+
 		sr.ErrorHandler(w, r, "Path not found", http.StatusNotFound)
 		return
 	}
@@ -115,6 +247,8 @@ func (sr *OpenAPIMux) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	handler = sr.resolveHandler(route.Operation.OperationID, route.Operation.Tags, handler)
+
 	input := &openapi3filter.RequestValidationInput{
 		Request:    r,
 		PathParams: pathParams,
@@ -135,7 +269,42 @@ func (sr *OpenAPIMux) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	handler.ServeHTTP(w, WithPathParams(r, pathParams))
+	r = WithRoute(WithPathParams(r, pathParams), route)
+	r = WithRequestCodec(r, sr.negotiateRequestCodec(r, route))
+	r = WithResponseCodec(r, sr.negotiateResponseCodec(r, route))
+
+	if !sr.ValidateResponses {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	resp := &BufferedResponse{
+		Status: rec.Code,
+		Header: rec.Header(),
+		Body:   rec.Body.Bytes(),
+	}
+
+	responseValidationInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 resp.Status,
+		Header:                 resp.Header,
+		Body:                   io.NopCloser(rec.Body),
+	}
+
+	if e := openapi3filter.ValidateResponse(r.Context(), responseValidationInput); e != nil {
+		responseErrorHandler := sr.ResponseErrorHandler
+		if responseErrorHandler == nil {
+			responseErrorHandler = DefaultResponseErrorHandler
+		}
+
+		responseErrorHandler(w, r, resp, e)
+		return
+	}
+
+	resp.WriteTo(w)
 }
 
 //Respond sends HTTP response
@@ -163,3 +332,41 @@ func chain(middlewares []func(http.Handler) http.Handler, endpoint http.Handler)
 func WithPathParams(r *http.Request, pathParams map[string]string) *http.Request {
 	return r.WithContext(context.WithValue(r.Context(), pathParamsKey, pathParams))
 }
+
+// WithRoute sets the in-context matched route for a request.
+func WithRoute(r *http.Request, route *openapi3filter.Route) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeKey, route))
+}
+
+// RouteFromContext returns the in-context matched route for a request, or nil
+// if no route was matched (or the handler is running outside the mux).
+func RouteFromContext(r *http.Request) *openapi3filter.Route {
+	route, _ := r.Context().Value(routeKey).(*openapi3filter.Route)
+	return route
+}
+
+// WithRequestCodec sets the in-context codec negotiated to decode a request.
+func WithRequestCodec(r *http.Request, codec Codec) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestCodecKey, codec))
+}
+
+// RequestCodecFromContext returns the in-context codec negotiated to decode a
+// request, or nil if none was negotiated (or the handler is running outside
+// the mux).
+func RequestCodecFromContext(r *http.Request) Codec {
+	codec, _ := r.Context().Value(requestCodecKey).(Codec)
+	return codec
+}
+
+// WithResponseCodec sets the in-context codec negotiated to encode a response.
+func WithResponseCodec(r *http.Request, codec Codec) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), responseCodecKey, codec))
+}
+
+// ResponseCodecFromContext returns the in-context codec negotiated to encode
+// a response, or nil if none was negotiated (or the handler is running
+// outside the mux).
+func ResponseCodecFromContext(r *http.Request) Codec {
+	codec, _ := r.Context().Value(responseCodecKey).(Codec)
+	return codec
+}
@@ -2,10 +2,16 @@ package openapimux
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
 )
 
 type httpResult struct {
@@ -70,13 +76,49 @@ func TestNewRouter(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Logf("Running %s", tt.name)
-		router, e := NewRouter(tt.schema...)
+		router, e := NewRouter(FromFile(tt.schema...))
 
 		tt.assert(router, e)
 		t.Logf("OK")
 	}
 }
 
+func TestNewRouterFromSwagger(t *testing.T) {
+	router, e := NewRouter(FromSwagger(nil))
+	if router != nil {
+		t.Errorf("router must be nil, got %+v", router)
+	}
+
+	if e == nil {
+		t.Error("error must not be nil")
+	}
+}
+
+// TestNewRouterBareStrings preserves back-compat with the original
+// NewRouter(apis ...string): plain strings still dispatch to FromFile without
+// being wrapped explicitly.
+func TestNewRouterBareStrings(t *testing.T) {
+	router, e := NewRouter("./testdata/v1.yaml", "./testdata/v2.yaml")
+	if router == nil {
+		t.Error("router must not be nil")
+	}
+
+	if e != nil {
+		t.Errorf("error must be nil, got %s", e.Error())
+	}
+}
+
+func TestNewRouterUnsupportedOption(t *testing.T) {
+	router, e := NewRouter(42)
+	if router != nil {
+		t.Errorf("router must be nil, got %+v", router)
+	}
+
+	if e == nil {
+		t.Error("error must not be nil")
+	}
+}
+
 type testGet1 struct{}
 
 func (h testGet1) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -114,7 +156,7 @@ var handlers = map[string]http.Handler{
 }
 
 func TestServeOneVersion(t *testing.T) {
-	router, _ := NewRouter("./testdata/v1.yaml")
+	router, _ := NewRouter(FromFile("./testdata/v1.yaml"))
 	router.UseHandlers(handlers)
 
 	tests := []httpTest{
@@ -159,7 +201,7 @@ func TestServeOneVersion(t *testing.T) {
 }
 
 func TestServeTwoVersions(t *testing.T) {
-	router, _ := NewRouter("./testdata/v1.yaml", "./testdata/v2.yaml")
+	router, _ := NewRouter(FromFile("./testdata/v1.yaml", "./testdata/v2.yaml"))
 	router.UseHandlers(handlers)
 
 	tests := []httpTest{
@@ -237,7 +279,7 @@ func TestUseMiddleware(t *testing.T) {
 		})
 	}
 
-	router, _ := NewRouter("./testdata/v1.yaml")
+	router, _ := NewRouter(FromFile("./testdata/v1.yaml"))
 	router.UseHandlers(handlers)
 	router.UseMiddleware(getMiddleware, postMiddleware)
 
@@ -265,6 +307,411 @@ func TestUseMiddleware(t *testing.T) {
 	runHTTPTest(t, router, tests)
 }
 
+func TestFallback(t *testing.T) {
+	router, _ := NewRouter(FromFile("./testdata/v1.yaml"))
+	router.UseHandlers(handlers)
+	router.Fallback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fallback OK"))
+	}))
+
+	tests := []httpTest{
+		httpTest{
+			name:   "known path still handled by mux",
+			method: http.MethodGet,
+			url:    "/v1",
+			expected: httpResult{
+				code: http.StatusOK,
+				body: "GET OK 1",
+			},
+		},
+		httpTest{
+			name:   "unknown path falls through",
+			method: http.MethodGet,
+			url:    "/noPath",
+			expected: httpResult{
+				code: http.StatusOK,
+				body: "fallback OK",
+			},
+		},
+	}
+
+	runHTTPTest(t, router, tests)
+}
+
+func TestRoutesAndWalk(t *testing.T) {
+	router, _ := NewRouter(FromFile("./testdata/v1.yaml"))
+	router.UseHandlers(handlers)
+
+	routes := router.Routes()
+	if len(routes) == 0 {
+		t.Fatal("expected at least one route")
+	}
+
+	var sawNoHandler bool
+	for _, route := range routes {
+		if route.OperationID == "testGet1" && !route.HasHandler {
+			t.Error("testGet1 should have a registered handler")
+		}
+
+		if !route.HasHandler {
+			sawNoHandler = true
+		}
+	}
+
+	if !sawNoHandler {
+		t.Error("expected at least one operation without a registered handler")
+	}
+
+	count := 0
+	e := router.Walk(func(method, pathPattern, operationID string, hasHandler bool) error {
+		count++
+		return nil
+	})
+
+	if e != nil {
+		t.Errorf("walk should not error, got %s", e.Error())
+	}
+
+	if count != len(routes) {
+		t.Errorf("walk visited %d operations, Routes() returned %d", count, len(routes))
+	}
+}
+
+// TestValidateResponses covers both the pass-through path, where a handler's
+// response matches its operation's declared schema, and the failure path,
+// where a mismatch is routed through ResponseErrorHandler instead of reaching
+// the client unchanged.
+func TestValidateResponses(t *testing.T) {
+	responses := openapi3.Responses{
+		"200": &openapi3.ResponseRef{
+			Value: &openapi3.Response{
+				Description: strPtr("ok"),
+				Content: openapi3.Content{
+					"application/json": &openapi3.MediaType{
+						Schema: &openapi3.SchemaRef{
+							Value: &openapi3.Schema{
+								Type: "object",
+								Properties: map[string]*openapi3.SchemaRef{
+									"name": &openapi3.SchemaRef{Value: &openapi3.Schema{Type: "string"}},
+								},
+								Required: []string{"name"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	swagger := &openapi3.Swagger{
+		OpenAPI: "3.0.0",
+		Info:    &openapi3.Info{Title: "test", Version: "1.0"},
+		Paths: openapi3.Paths{
+			"/valid":   &openapi3.PathItem{Get: &openapi3.Operation{OperationID: "getValid", Responses: responses}},
+			"/invalid": &openapi3.PathItem{Get: &openapi3.Operation{OperationID: "getInvalid", Responses: responses}},
+		},
+	}
+
+	router, e := NewRouter(FromSwagger(swagger))
+	if e != nil {
+		t.Fatalf("NewRouter failed: %s", e.Error())
+	}
+
+	router.ValidateResponses = true
+	router.ResponseErrorHandler = func(w http.ResponseWriter, r *http.Request, resp *BufferedResponse, err error) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("invalid response: " + err.Error()))
+	}
+
+	router.UseHandlers(map[string]http.Handler{
+		"getValid": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name":"bob"}`))
+		}),
+		"getInvalid": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		}),
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/valid", nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("valid response: got status %d, want %d", res.Code, http.StatusOK)
+	}
+
+	if got := res.Body.String(); got != `{"name":"bob"}` {
+		t.Errorf("valid response: got body %q", got)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/invalid", nil)
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadGateway {
+		t.Errorf("invalid response: got status %d, want %d", res.Code, http.StatusBadGateway)
+	}
+
+	if got := res.Body.String(); !strings.Contains(got, "invalid response:") {
+		t.Errorf("invalid response: got body %q, want it routed through ResponseErrorHandler", got)
+	}
+}
+
+// TestUseMiddlewareForAndForTag checks that middleware runs in the documented
+// order: global, then tag-scoped, then operation-scoped, then the handler.
+func TestUseMiddlewareForAndForTag(t *testing.T) {
+	swagger := &openapi3.Swagger{
+		OpenAPI: "3.0.0",
+		Info:    &openapi3.Info{Title: "test", Version: "1.0"},
+		Paths: openapi3.Paths{
+			"/op": &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "theOp",
+					Tags:        []string{"theTag"},
+					Responses: openapi3.Responses{
+						"200": &openapi3.ResponseRef{Value: &openapi3.Response{Description: strPtr("ok")}},
+					},
+				},
+			},
+		},
+	}
+
+	router, e := NewRouter(FromSwagger(swagger))
+	if e != nil {
+		t.Fatalf("NewRouter failed: %s", e.Error())
+	}
+
+	var order []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	router.UseMiddleware(record("global"))
+	router.UseMiddlewareForTag("theTag", record("tag"))
+	router.UseMiddlewareFor("theOp", record("operation"))
+	router.UseHandlers(map[string]http.Handler{
+		"theOp": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "handler")
+			w.Write([]byte("OK"))
+		}),
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/op", nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	if got, want := strings.Join(order, ","), "global,tag,operation,handler"; got != want {
+		t.Errorf("got middleware order %q, want %q", got, want)
+	}
+}
+
+// TestRegisterOperation covers the full typed-handler path: decoding the
+// request body, overlaying path parameters, encoding the response with the
+// negotiated codec, and mapping a returned error. It also regression-tests
+// the codec negotiation fix from TestNegotiateCodecRespectsDeclaredContentTypes
+// as it manifests through RegisterOperation: a request body must still be
+// decoded as JSON even when Accept asks for a response in another format.
+func TestRegisterOperation(t *testing.T) {
+	type createThingReq struct {
+		ID   string `openapi:"id"`
+		Name string `json:"name"`
+	}
+
+	type createThingResp struct {
+		Name string `json:"name" xml:"name"`
+		ID   string `json:"id" xml:"id"`
+	}
+
+	swagger := &openapi3.Swagger{
+		OpenAPI: "3.0.0",
+		Info:    &openapi3.Info{Title: "test", Version: "1.0"},
+		Paths: openapi3.Paths{
+			"/things/{id}": &openapi3.PathItem{
+				Post: &openapi3.Operation{
+					OperationID: "createThing",
+					Parameters: openapi3.Parameters{
+						&openapi3.ParameterRef{Value: &openapi3.Parameter{
+							Name:     "id",
+							In:       "path",
+							Required: true,
+							Schema:   &openapi3.SchemaRef{Value: &openapi3.Schema{Type: "string"}},
+						}},
+					},
+					RequestBody: &openapi3.RequestBodyRef{
+						Value: &openapi3.RequestBody{
+							Content: openapi3.Content{"application/json": &openapi3.MediaType{}},
+						},
+					},
+					Responses: openapi3.Responses{
+						"200": &openapi3.ResponseRef{
+							Value: &openapi3.Response{
+								Description: strPtr("ok"),
+								Content: openapi3.Content{
+									"application/json": &openapi3.MediaType{},
+									"application/xml":  &openapi3.MediaType{},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	router, e := NewRouter(FromSwagger(swagger))
+	if e != nil {
+		t.Fatalf("NewRouter failed: %s", e.Error())
+	}
+
+	RegisterOperation(router, "createThing", func(ctx context.Context, req createThingReq) (createThingResp, error) {
+		if req.Name == "" {
+			return createThingResp{}, errors.New("name required")
+		}
+
+		return createThingResp{Name: req.Name, ID: req.ID}, nil
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "/things/42", bytes.NewReader([]byte(`{"name":"bob"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/xml")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d, body %q", res.Code, http.StatusOK, res.Body.String())
+	}
+
+	if ct := res.Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("got Content-Type %q, want application/xml", ct)
+	}
+
+	if got := res.Body.String(); !strings.Contains(got, "<name>bob</name>") || !strings.Contains(got, "<id>42</id>") {
+		t.Errorf("got body %q, want it to contain the decoded name and path-bound id", got)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, "/things/42", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d, body %q", res.Code, http.StatusInternalServerError, res.Body.String())
+	}
+
+	if got := res.Body.String(); !strings.Contains(got, "name required") {
+		t.Errorf("got body %q, want the mapped error message", got)
+	}
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	router, _ := NewRouter()
+
+	tests := []struct {
+		name             string
+		accept           string
+		ctype            string
+		expectedRequest  string
+		expectedResponse string
+	}{
+		{
+			name:             "defaults to json",
+			expectedRequest:  "application/json",
+			expectedResponse: "application/json",
+		},
+		{
+			name:             "accept only affects the response",
+			accept:           "application/xml",
+			ctype:            "application/json",
+			expectedRequest:  "application/json",
+			expectedResponse: "application/xml",
+		},
+		{
+			name:             "content-type only affects the request",
+			ctype:            "application/x-www-form-urlencoded",
+			expectedRequest:  "application/x-www-form-urlencoded",
+			expectedResponse: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if tt.ctype != "" {
+			req.Header.Set("Content-Type", tt.ctype)
+		}
+
+		requestCodec := router.negotiateRequestCodec(req, nil)
+		if got := requestCodec.ContentTypes()[0]; got != tt.expectedRequest {
+			t.Errorf("%s: got request codec %q, want %q", tt.name, got, tt.expectedRequest)
+		}
+
+		responseCodec := router.negotiateResponseCodec(req, nil)
+		if got := responseCodec.ContentTypes()[0]; got != tt.expectedResponse {
+			t.Errorf("%s: got response codec %q, want %q", tt.name, got, tt.expectedResponse)
+		}
+	}
+}
+
+// TestNegotiateCodecRespectsDeclaredContentTypes reproduces the case where a
+// request body only declares application/json but the response declares both
+// application/json and application/xml: an Accept: application/xml must only
+// steer the response codec, never the request codec used to decode the body.
+func TestNegotiateCodecRespectsDeclaredContentTypes(t *testing.T) {
+	route := &openapi3filter.Route{
+		Operation: &openapi3.Operation{
+			OperationID: "testNegotiate",
+			RequestBody: &openapi3.RequestBodyRef{
+				Value: &openapi3.RequestBody{
+					Content: openapi3.Content{
+						"application/json": &openapi3.MediaType{},
+					},
+				},
+			},
+			Responses: openapi3.Responses{
+				"200": &openapi3.ResponseRef{
+					Value: &openapi3.Response{
+						Content: openapi3.Content{
+							"application/json": &openapi3.MediaType{},
+							"application/xml":  &openapi3.MediaType{},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	router, _ := NewRouter()
+
+	req, _ := http.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/xml")
+
+	requestCodec := router.negotiateRequestCodec(req, route)
+	if got := requestCodec.ContentTypes()[0]; got != "application/json" {
+		t.Errorf("got request codec %q, want application/json", got)
+	}
+
+	responseCodec := router.negotiateResponseCodec(req, route)
+	if got := responseCodec.ContentTypes()[0]; got != "application/xml" {
+		t.Errorf("got response codec %q, want application/xml", got)
+	}
+}
+
+// strPtr returns a pointer to s, for populating the *string fields
+// (e.g. Response.Description) the openapi3 types require to validate.
+func strPtr(s string) *string {
+	return &s
+}
+
 func runHTTPTest(t *testing.T, router *OpenAPIMux, tests []httpTest) {
 	for _, tt := range tests {
 		req, err := http.NewRequest(tt.method, tt.url, tt.body)
@@ -0,0 +1,92 @@
+package openapimux
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// RouterOption loads one or more OpenAPI schemas into a router being built by
+// NewRouter.
+type RouterOption func(*routerConfig) error
+
+type routerConfig struct {
+	swaggers []*openapi3.Swagger
+}
+
+// FromFile loads a schema from a file on disk, the same way the original
+// NewRouter(apis ...string) did.
+func FromFile(paths ...string) RouterOption {
+	return func(cfg *routerConfig) error {
+		for _, path := range paths {
+			swagger, e := openapi3.NewSwaggerLoader().LoadSwaggerFromFile(path)
+			if e != nil {
+				return e
+			}
+
+			cfg.swaggers = append(cfg.swaggers, swagger)
+		}
+
+		return nil
+	}
+}
+
+// FromURL fetches and loads a schema from a URL.
+func FromURL(urls ...string) RouterOption {
+	return func(cfg *routerConfig) error {
+		for _, u := range urls {
+			location, e := url.Parse(u)
+			if e != nil {
+				return e
+			}
+
+			swagger, e := openapi3.NewSwaggerLoader().LoadSwaggerFromURI(location)
+			if e != nil {
+				return e
+			}
+
+			cfg.swaggers = append(cfg.swaggers, swagger)
+		}
+
+		return nil
+	}
+}
+
+// FromBytes loads a schema already held in memory, e.g. embedded via
+// //go:embed or fetched from a config service. format is informational and
+// currently accepts "json", "yaml"/"yml" or ""; the underlying loader detects
+// either automatically.
+func FromBytes(data []byte, format string) RouterOption {
+	return func(cfg *routerConfig) error {
+		switch format {
+		case "json", "yaml", "yml", "":
+		default:
+			return fmt.Errorf("openapimux: unsupported schema format %q", format)
+		}
+
+		swagger, e := openapi3.NewSwaggerLoader().LoadSwaggerFromData(data)
+		if e != nil {
+			return e
+		}
+
+		cfg.swaggers = append(cfg.swaggers, swagger)
+
+		return nil
+	}
+}
+
+// FromSwagger uses a schema that's already been parsed into a *openapi3.Swagger,
+// e.g. built programmatically or shared with another router.
+func FromSwagger(sw *openapi3.Swagger) RouterOption {
+	return func(cfg *routerConfig) error {
+		if sw == nil {
+			return errors.New("openapimux: nil swagger")
+		}
+
+		cfg.swaggers = append(cfg.swaggers, sw)
+
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package openapimux
+
+// RouteInfo describes a single operation across all loaded schemas.
+type RouteInfo struct {
+	Method      string
+	PathPattern string
+	OperationID string
+	HasHandler  bool
+}
+
+// Routes enumerates every operation across all loaded schemas, indicating
+// which have a registered handler.
+func (sr *OpenAPIMux) Routes() []RouteInfo {
+	var routes []RouteInfo
+
+	sr.Walk(func(method, pathPattern, operationID string, hasHandler bool) error {
+		routes = append(routes, RouteInfo{
+			Method:      method,
+			PathPattern: pathPattern,
+			OperationID: operationID,
+			HasHandler:  hasHandler,
+		})
+
+		return nil
+	})
+
+	return routes
+}
+
+// Walk calls fn for every operation across all loaded schemas, indicating
+// which have a registered handler. It stops and returns the first error fn
+// returns.
+func (sr *OpenAPIMux) Walk(fn func(method, pathPattern, operationID string, hasHandler bool) error) error {
+	for _, swagger := range sr.swaggers {
+		if swagger == nil {
+			continue
+		}
+
+		for pathPattern, pathItem := range swagger.Paths {
+			if pathItem == nil {
+				continue
+			}
+
+			for method, operation := range pathItem.Operations() {
+				if operation == nil || operation.OperationID == "" {
+					continue
+				}
+
+				_, hasHandler := sr.handlers[operation.OperationID]
+
+				if e := fn(method, pathPattern, operation.OperationID, hasHandler); e != nil {
+					return e
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package openapimux
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+// ErrorMapper turns an error returned by a typed handler into the status code
+// and body written back to the client.
+type ErrorMapper func(error) (int, any)
+
+// errorBody is the default mapped error shape. It carries both json and xml
+// tags so it round-trips through whichever codec the client negotiated,
+// unlike a bare map which the xml codec can't marshal at all.
+type errorBody struct {
+	Error string `json:"error" xml:"error"`
+}
+
+// DefaultErrorMapper maps any error to a 500 with an {"error": "..."} body.
+func DefaultErrorMapper(err error) (int, any) {
+	return http.StatusInternalServerError, errorBody{Error: err.Error()}
+}
+
+// RegisterOperation registers a typed handler for operationID. The mux decodes
+// the request body plus the operation's path/query parameters into a Req value,
+// struct fields matching a parameter are tagged with `openapi:"<name>"`, after
+// the usual request validation, invokes fn, then encodes the returned Resp with
+// the response codec negotiated from the Accept header (JSON by default) using
+// the operation's declared success status code (defaulting to 200).
+// Errors returned by fn are routed through mux.ErrorMapper, or DefaultErrorMapper
+// if unset.
+func RegisterOperation[Req any, Resp any](mux *OpenAPIMux, operationID string, fn func(ctx context.Context, req Req) (Resp, error)) {
+	mux.UseHandlers(map[string]http.Handler{
+		operationID: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req Req
+
+			if e := bindRequest(r, RouteFromContext(r), &req); e != nil {
+				mux.writeTypedError(w, r, e)
+				return
+			}
+
+			resp, e := fn(r.Context(), req)
+			if e != nil {
+				mux.writeTypedError(w, r, e)
+				return
+			}
+
+			codec := ResponseCodecFromContext(r)
+			if codec == nil {
+				codec = jsonCodec{}
+			}
+
+			if e := codec.Encode(w, successStatus(RouteFromContext(r)), resp); e != nil {
+				writeEncodeFailure(w, e)
+			}
+		}),
+	})
+}
+
+func (sr *OpenAPIMux) writeTypedError(w http.ResponseWriter, r *http.Request, err error) {
+	mapper := sr.ErrorMapper
+	if mapper == nil {
+		mapper = DefaultErrorMapper
+	}
+
+	status, body := mapper(err)
+
+	codec := ResponseCodecFromContext(r)
+	if codec == nil {
+		codec = jsonCodec{}
+	}
+
+	if e := codec.Encode(w, status, body); e != nil {
+		writeEncodeFailure(w, e)
+	}
+}
+
+// writeEncodeFailure is a last-resort fallback for when the negotiated codec
+// itself fails to encode a response (e.g. xmlCodec given a type with no
+// valid root element, or msgpackCodec given an unsupported type). The JSON,
+// XML and msgpack codecs have already written the status and Content-Type by
+// this point; formCodec can fail before writing them, in which case the
+// status defaults to 200. Either way this just gets the real error onto the
+// wire as the body instead of leaving it empty or truncated.
+func writeEncodeFailure(w http.ResponseWriter, err error) {
+	io.WriteString(w, "openapimux: failed to encode response: "+err.Error())
+}
+
+// bindRequest decodes the request body (if any), using the codec negotiated
+// from the request's Content-Type, into req, then overlays path/query
+// parameters declared on route's operation onto struct fields tagged
+// `openapi:"<name>"`.
+func bindRequest(r *http.Request, route *openapi3filter.Route, req any) error {
+	codec := RequestCodecFromContext(r)
+	if codec == nil {
+		codec = jsonCodec{}
+	}
+
+	if e := codec.Decode(r, req); e != nil {
+		return e
+	}
+
+	if route == nil || route.Operation == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(req)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	v = v.Elem()
+	t := v.Type()
+	query := r.URL.Query()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("openapi")
+		if name == "" {
+			continue
+		}
+
+		var raw string
+		var present bool
+
+		for _, p := range route.Operation.Parameters {
+			if p.Value == nil || p.Value.Name != name {
+				continue
+			}
+
+			switch p.Value.In {
+			case "path":
+				raw, present = PathParam(r, name), true
+			case "query":
+				raw, present = query.Get(name), query.Has(name)
+			}
+		}
+
+		if !present {
+			continue
+		}
+
+		if e := setField(v.Field(i), raw); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// setField assigns the string value of a path/query parameter to a struct field.
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, e := strconv.ParseInt(raw, 10, 64)
+		if e != nil {
+			return e
+		}
+		field.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, e := strconv.ParseFloat(raw, 64)
+		if e != nil {
+			return e
+		}
+		field.SetFloat(n)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(raw)
+		if e != nil {
+			return e
+		}
+		field.SetBool(b)
+	}
+
+	return nil
+}
+
+// successStatus returns the lowest declared 2xx status code for route's
+// operation, or 200 if none is declared.
+func successStatus(route *openapi3filter.Route) int {
+	if route == nil || route.Operation == nil || route.Operation.Responses == nil {
+		return http.StatusOK
+	}
+
+	codes := make([]int, 0, len(route.Operation.Responses))
+	for code := range route.Operation.Responses {
+		n, e := strconv.Atoi(code)
+		if e == nil && n >= 200 && n < 300 {
+			codes = append(codes, n)
+		}
+	}
+
+	if len(codes) == 0 {
+		return http.StatusOK
+	}
+
+	sort.Ints(codes)
+
+	return codes[0]
+}